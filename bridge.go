@@ -0,0 +1,174 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+const descriptionFetchTimeout = 3 * time.Second
+
+// Bridge describes a Hue bridge as advertised by its description.xml, so
+// callers don't each have to fetch and parse it themselves.
+type Bridge struct {
+	IP           string
+	Port         int
+	ID           string
+	SerialNumber string
+	UDN          string
+	ModelName    string
+	ModelNumber  string
+	FriendlyName string
+	APIVersion   string
+}
+
+// upnpRoot mirrors the <root> element of a UPnP description.xml.
+type upnpRoot struct {
+	XMLName xml.Name   `xml:"root"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// upnpDevice mirrors the <device> element of a UPnP description.xml.
+type upnpDevice struct {
+	FriendlyName string `xml:"friendlyName"`
+	ModelName    string `xml:"modelName"`
+	ModelNumber  string `xml:"modelNumber"`
+	SerialNumber string `xml:"serialNumber"`
+	UDN          string `xml:"UDN"`
+	APIVersion   string `xml:"apiversion"`
+}
+
+// DiscoverBridges runs SSDP discovery and, for every hit, follows its
+// LOCATION header to fetch and parse description.xml, returning a rich
+// Bridge for each one instead of a bare IP string. opts.Interfaces
+// overrides the auto-detected interface set SSDP discovery runs on.
+func DiscoverBridges(ctx context.Context, opts DiscoverOptions) ([]Bridge, error) {
+	ifaces, err := resolveInterfaces(opts.Interfaces)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []ssdpHit
+	_, err = upnpDiscoverOnInterfacesCtx(ctx, "ssdp:discover", ifaces, func(hit ssdpHit) {
+		hits = append(hits, hit)
+	})
+	if err != nil && len(hits) == 0 {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var bridges []Bridge
+
+	for _, hit := range hits {
+		hit := hit
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bridge, err := fetchBridgeDescription(ctx, hit)
+			if err != nil {
+				return // unreachable or not a Hue bridge after all, skip it
+			}
+			mu.Lock()
+			bridges = append(bridges, bridge)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return bridges, nil
+}
+
+// fetchBridgeDescription follows hit's LOCATION URL, fetches
+// description.xml and parses it into a Bridge.
+func fetchBridgeDescription(ctx context.Context, hit ssdpHit) (Bridge, error) {
+	location, err := url.Parse(hit.location)
+	if err != nil {
+		return Bridge{}, fmt.Errorf("invalid LOCATION %q: %w", hit.location, err)
+	}
+
+	port := 80
+	if p := location.Port(); p != "" {
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			return Bridge{}, fmt.Errorf("invalid port in LOCATION %q: %w", hit.location, err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hit.location, nil)
+	if err != nil {
+		return Bridge{}, err
+	}
+
+	client := http.Client{Timeout: descriptionFetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Bridge{}, err
+	}
+	defer resp.Body.Close()
+
+	decoder := xml.NewDecoder(resp.Body)
+	decoder.CharsetReader = xmlCharsetReader
+
+	var root upnpRoot
+	if err := decoder.Decode(&root); err != nil {
+		return Bridge{}, fmt.Errorf("decoding description.xml from %s: %w", hit.location, err)
+	}
+
+	id := hit.bridgeID // prefer the SSDP hue-bridgeid header; it's authoritative when present
+	if id == "" {
+		id = strings.TrimPrefix(root.Device.UDN, "uuid:")
+	}
+
+	return Bridge{
+		IP:           hit.ip,
+		Port:         port,
+		ID:           id,
+		SerialNumber: root.Device.SerialNumber,
+		UDN:          root.Device.UDN,
+		ModelName:    root.Device.ModelName,
+		ModelNumber:  root.Device.ModelNumber,
+		FriendlyName: root.Device.FriendlyName,
+		APIVersion:   root.Device.APIVersion,
+	}, nil
+}
+
+// xmlCharsetReader gives xml.Decoder real support for the non-UTF-8
+// encodings occasionally seen in description.xml on older bridge
+// firmware (ISO-8859-1 and Windows-1252 in practice), by handing the
+// declared charset label to golang.org/x/net/html/charset - encoding/xml
+// rejects non-UTF-8 bytes outright, so passing an unrecognized charset
+// through unchanged is not a usable fallback.
+func xmlCharsetReader(label string, input io.Reader) (io.Reader, error) {
+	return charset.NewReaderLabel(label, input)
+}
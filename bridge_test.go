@@ -0,0 +1,171 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestXMLCharsetReaderUTF8(t *testing.T) {
+	r, err := xmlCharsetReader("utf-8", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("xmlCharsetReader returned error: %v", err)
+	}
+	if r == nil {
+		t.Fatal("expected a non-nil reader")
+	}
+}
+
+func TestXMLCharsetReaderLatin1(t *testing.T) {
+	// 0xE9 is "é" in ISO-8859-1.
+	raw := []byte{'c', 0xE9}
+
+	r, err := xmlCharsetReader("iso-8859-1", strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("xmlCharsetReader returned error: %v", err)
+	}
+
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading decoded output: %v", err)
+	}
+	if string(decoded) != "cé" {
+		t.Errorf("decoded = %q, want %q", decoded, "cé")
+	}
+}
+
+func TestXMLCharsetReaderWindows1252(t *testing.T) {
+	// 0x93 is U+201C "“" in windows-1252, but a control character in
+	// ISO-8859-1 - this only decodes correctly if windows-1252 is
+	// actually being used, not a latin1 fallback.
+	raw := []byte{'a', 0x93, 'b'}
+
+	r, err := xmlCharsetReader("windows-1252", strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("xmlCharsetReader returned error: %v", err)
+	}
+
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading decoded output: %v", err)
+	}
+	if want := "a“b"; string(decoded) != want {
+		t.Errorf("decoded = %q, want %q", decoded, want)
+	}
+}
+
+func TestFetchBridgeDescription(t *testing.T) {
+	const descriptionXML = `<?xml version="1.0"?>
+<root>
+	<device>
+		<friendlyName>Philips hue (192.168.1.2)</friendlyName>
+		<modelName>Philips hue bridge 2015</modelName>
+		<modelNumber>BSB002</modelNumber>
+		<serialNumber>001788a1b2c3</serialNumber>
+		<UDN>uuid:2f402f80-da50-11e1-9b23-001788a1b2c3</UDN>
+		<apiversion>1.55.0</apiversion>
+	</device>
+</root>`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(descriptionXML))
+	}))
+	defer server.Close()
+
+	hit := ssdpHit{ip: "192.168.1.2", location: server.URL + "/description.xml", bridgeID: "001788FFFE09A206"}
+
+	bridge, err := fetchBridgeDescription(context.Background(), hit)
+	if err != nil {
+		t.Fatalf("fetchBridgeDescription returned error: %v", err)
+	}
+	if bridge.ID != "001788FFFE09A206" {
+		t.Errorf("ID = %q, want the hue-bridgeid from the SSDP hit, not the UDN", bridge.ID)
+	}
+	if bridge.FriendlyName != "Philips hue (192.168.1.2)" {
+		t.Errorf("FriendlyName = %q", bridge.FriendlyName)
+	}
+	if bridge.ModelNumber != "BSB002" {
+		t.Errorf("ModelNumber = %q", bridge.ModelNumber)
+	}
+	if bridge.APIVersion != "1.55.0" {
+		t.Errorf("APIVersion = %q", bridge.APIVersion)
+	}
+}
+
+func TestFetchBridgeDescriptionWindows1252(t *testing.T) {
+	// 0xE9 is "é" in windows-1252. encoding/xml rejects this byte as
+	// invalid UTF-8 unless CharsetReader actually decodes it, so a
+	// successful Decode here proves the full fetch path, not just
+	// xmlCharsetReader in isolation.
+	descriptionXML := []byte(`<?xml version="1.0" encoding="windows-1252"?>
+<root><device><friendlyName>Caf`)
+	descriptionXML = append(descriptionXML, 0xE9)
+	descriptionXML = append(descriptionXML, []byte(` hue</friendlyName></device></root>`)...)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(descriptionXML)
+	}))
+	defer server.Close()
+
+	hit := ssdpHit{ip: "192.168.1.2", location: server.URL + "/description.xml"}
+
+	bridge, err := fetchBridgeDescription(context.Background(), hit)
+	if err != nil {
+		t.Fatalf("fetchBridgeDescription returned error: %v", err)
+	}
+	if want := "Café hue"; bridge.FriendlyName != want {
+		t.Errorf("FriendlyName = %q, want %q", bridge.FriendlyName, want)
+	}
+}
+
+func TestFetchBridgeDescriptionFallsBackToUDN(t *testing.T) {
+	const descriptionXML = `<?xml version="1.0"?>
+<root><device><UDN>uuid:2f402f80-da50-11e1-9b23-001788a1b2c3</UDN></device></root>`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(descriptionXML))
+	}))
+	defer server.Close()
+
+	hit := ssdpHit{ip: "192.168.1.2", location: server.URL + "/description.xml"} // no bridgeID
+
+	bridge, err := fetchBridgeDescription(context.Background(), hit)
+	if err != nil {
+		t.Fatalf("fetchBridgeDescription returned error: %v", err)
+	}
+	if bridge.ID != "2f402f80-da50-11e1-9b23-001788a1b2c3" {
+		t.Errorf("ID = %q, want the UDN with its uuid: prefix stripped", bridge.ID)
+	}
+}
+
+func TestFetchBridgeDescriptionInvalidLocation(t *testing.T) {
+	hit := ssdpHit{ip: "192.168.1.2", location: "://not-a-url"}
+
+	if _, err := fetchBridgeDescription(context.Background(), hit); err == nil {
+		t.Fatal("expected an error for an invalid LOCATION URL")
+	}
+}
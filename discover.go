@@ -0,0 +1,168 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const verifyTimeout = 3 * time.Second
+
+// DiscoverOptions controls the behavior of Discover.
+type DiscoverOptions struct {
+	// Verify fetches each candidate's description.xml and only keeps
+	// bridges that identify themselves as a Philips Hue bridge. This
+	// filters out stale entries returned by the N-UPnP cloud portal.
+	// Discover applies it to the merged result of all three discovery
+	// paths; DiscoverStream and DiscoverContext apply it only to N-UPnP
+	// results, since SSDP and mDNS hits are already validated by their
+	// own protocols before being emitted.
+	Verify bool
+
+	// Interfaces overrides the auto-detected set of interfaces SSDP
+	// discovery is run on. Leave nil to auto-detect via
+	// discoverableInterfaces; pass an explicit list to scope discovery
+	// to particular NICs or to work around a host where auto-detection
+	// picks the wrong ones.
+	Interfaces []net.Interface
+}
+
+// Discover runs SSDP, N-UPnP and mDNS discovery concurrently and returns
+// the deduplicated union of bridge IPs found by any path. N-UPnP is the
+// fallback Philips recommends for networks that block SSDP multicast,
+// and mDNS is how newer bridge firmwares are moving towards advertising
+// themselves, so both are treated as first-class paths here rather than
+// left to callers.
+func Discover(opts DiscoverOptions) ([]string, error) {
+	var wg sync.WaitGroup
+	var ssdpIPs, nupnpIPs, mdnsIPs []string
+	var ssdpErr, nupnpErr, mdnsErr error
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		ifaces, err := resolveInterfaces(opts.Interfaces)
+		if err != nil {
+			ssdpErr = err
+			return
+		}
+		hits, _, err := upnpDiscoverOnInterfaces("ssdp:discover", ifaces)
+		ssdpErr = err
+		for _, hit := range hits {
+			ssdpIPs = append(ssdpIPs, hit.ip)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		nupnpIPs, nupnpErr = nupnpDiscover(context.Background())
+	}()
+	go func() {
+		defer wg.Done()
+		mdnsIPs, mdnsErr = mdnsDiscover(upnpTimeout)
+	}()
+	wg.Wait()
+
+	if ssdpErr != nil && nupnpErr != nil && mdnsErr != nil {
+		return nil, ssdpErr
+	}
+
+	merged := mergeUnique(ssdpIPs, nupnpIPs, mdnsIPs)
+	if !opts.Verify {
+		return merged, nil
+	}
+	return filterHueBridges(context.Background(), merged), nil
+}
+
+// mergeUnique combines several IP lists, dropping duplicates while
+// preserving the order the IPs were first seen in.
+func mergeUnique(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var merged []string
+	for _, list := range lists {
+		for _, ip := range list {
+			if seen[ip] {
+				continue
+			}
+			seen[ip] = true
+			merged = append(merged, ip)
+		}
+	}
+	return merged
+}
+
+// filterHueBridges fetches description.xml from every candidate IP
+// concurrently and keeps only those that respond as a Philips Hue bridge.
+// This cross-check guards against stale entries served by the N-UPnP
+// cloud portal. It returns as soon as ctx is cancelled instead of
+// waiting out verifyTimeout on every candidate.
+func filterHueBridges(ctx context.Context, ips []string) []string {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var verified []string
+
+	for _, ip := range ips {
+		ip := ip
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if isHueBridge(ctx, ip) {
+				mu.Lock()
+				verified = append(verified, ip)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return verified
+}
+
+// isHueBridge fetches description.xml from ip and checks whether it
+// identifies itself as a Philips Hue bridge. The request is bound to
+// ctx so callers like DiscoverStream can abort it instead of always
+// waiting out verifyTimeout.
+func isHueBridge(ctx context.Context, ip string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+ip+"/description.xml", nil)
+	if err != nil {
+		return false
+	}
+
+	client := http.Client{Timeout: verifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return false
+	}
+
+	content := string(body)
+	return strings.Contains(content, "IpBridge") || strings.Contains(content, "Philips")
+}
@@ -0,0 +1,154 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// DiscoverContext runs the same SSDP/N-UPnP/mDNS discovery as Discover,
+// but returns as soon as ctx is cancelled instead of always waiting out
+// upnpTimeout. It's a thin wrapper around DiscoverStream that collects
+// every IP it emits.
+func DiscoverContext(ctx context.Context, opts DiscoverOptions) ([]string, error) {
+	ips, errs := DiscoverStream(ctx, opts)
+
+	var merged []string
+	for ip := range ips {
+		merged = append(merged, ip)
+	}
+	return merged, <-errs
+}
+
+// DiscoverStream runs SSDP, N-UPnP and mDNS discovery concurrently and
+// streams each newly-found, deduplicated bridge IP on the returned
+// channel as soon as it's validated, rather than only after discovery
+// finishes. Both channels are closed once discovery completes or ctx is
+// done, whichever happens first - callers should range over the IP
+// channel and then receive from the error channel. This is meant for
+// interactive callers (e.g. a setup UI) that want to show bridges as
+// they appear and let the user abort the search early. opts.Interfaces
+// overrides the auto-detected interface set SSDP discovery runs on, and
+// opts.Verify gates N-UPnP results behind the same description.xml
+// cross-check Discover uses, since those are the only results here not
+// already validated by their own discovery protocol.
+func DiscoverStream(ctx context.Context, opts DiscoverOptions) (<-chan string, <-chan error) {
+	out := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		var mu sync.Mutex
+		seen := make(map[string]bool)
+		var firstErr error
+
+		emit := func(ip string) {
+			mu.Lock()
+			if seen[ip] {
+				mu.Unlock()
+				return
+			}
+			seen[ip] = true
+			mu.Unlock()
+
+			select {
+			case out <- ip:
+			case <-ctx.Done():
+			}
+		}
+		recordErr := func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			ifaces, err := resolveInterfaces(opts.Interfaces)
+			if err != nil {
+				recordErr(err)
+				return
+			}
+			if _, err := upnpDiscoverOnInterfacesCtx(ctx, "ssdp:discover", ifaces, func(hit ssdpHit) {
+				emit(hit.ip)
+			}); err != nil {
+				recordErr(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			ips, err := nupnpDiscover(ctx)
+			if err != nil {
+				recordErr(err)
+				return
+			}
+			if !opts.Verify {
+				for _, ip := range ips {
+					emit(ip)
+				}
+				return
+			}
+			// Unlike SSDP and mDNS, N-UPnP results come straight from
+			// Philips' cloud portal and can point at bridges that have
+			// since moved or disappeared, so they need the same
+			// description.xml cross-check filterHueBridges does for
+			// Discover before they're trusted.
+			var vwg sync.WaitGroup
+			for _, ip := range ips {
+				ip := ip
+				vwg.Add(1)
+				go func() {
+					defer vwg.Done()
+					if isHueBridge(ctx, ip) {
+						emit(ip)
+					}
+				}()
+			}
+			vwg.Wait()
+		}()
+		go func() {
+			defer wg.Done()
+			ips, err := mdnsDiscoverContext(ctx, upnpTimeout)
+			if err != nil {
+				recordErr(err)
+				return
+			}
+			for _, ip := range ips {
+				emit(ip)
+			}
+		}()
+		wg.Wait()
+
+		if firstErr != nil {
+			errs <- firstErr
+		}
+	}()
+
+	return out, errs
+}
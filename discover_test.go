@@ -0,0 +1,217 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeUnique(t *testing.T) {
+	got := mergeUnique(
+		[]string{"192.168.1.2", "192.168.1.3"},
+		[]string{"192.168.1.3", "192.168.1.4"},
+		[]string{"192.168.1.2"},
+	)
+	want := []string{"192.168.1.2", "192.168.1.3", "192.168.1.4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeUnique = %v, want %v", got, want)
+	}
+}
+
+func TestMergeUniqueNoLists(t *testing.T) {
+	if got := mergeUnique(); got != nil {
+		t.Errorf("mergeUnique() = %v, want nil", got)
+	}
+}
+
+func descriptionXMLServer(t *testing.T, modelName string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<root><device><modelName>%s</modelName></device></root>`, modelName)
+	}))
+}
+
+func serverAddr(s *httptest.Server) string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestIsHueBridge(t *testing.T) {
+	bridge := descriptionXMLServer(t, "Philips hue bridge 2015")
+	defer bridge.Close()
+
+	if !isHueBridge(context.Background(), serverAddr(bridge)) {
+		t.Error("isHueBridge = false, want true for a genuine bridge description")
+	}
+}
+
+func TestIsHueBridgeRejectsNonBridge(t *testing.T) {
+	other := descriptionXMLServer(t, "Some Other UPnP Device")
+	defer other.Close()
+
+	if isHueBridge(context.Background(), serverAddr(other)) {
+		t.Error("isHueBridge = true, want false for a non-Hue description")
+	}
+}
+
+func TestIsHueBridgeUnreachable(t *testing.T) {
+	if isHueBridge(context.Background(), "127.0.0.1:1") {
+		t.Error("isHueBridge = true, want false when the host is unreachable")
+	}
+}
+
+func TestIsHueBridgeCancelledContext(t *testing.T) {
+	bridge := descriptionXMLServer(t, "Philips hue bridge")
+	defer bridge.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if isHueBridge(ctx, serverAddr(bridge)) {
+		t.Error("isHueBridge = true, want false once ctx is already cancelled")
+	}
+}
+
+func TestFilterHueBridges(t *testing.T) {
+	bridge := descriptionXMLServer(t, "Philips hue bridge")
+	defer bridge.Close()
+	other := descriptionXMLServer(t, "Some Other UPnP Device")
+	defer other.Close()
+
+	got := filterHueBridges(context.Background(), []string{serverAddr(bridge), serverAddr(other)})
+	want := []string{serverAddr(bridge)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filterHueBridges = %v, want %v", got, want)
+	}
+}
+
+// nupnpServer starts an httptest server standing in for discovery.meethue.com
+// and points nupnpEndpoint at it for the duration of the test.
+func nupnpServer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	orig := nupnpEndpoint
+	nupnpEndpoint = s.URL
+	t.Cleanup(func() {
+		nupnpEndpoint = orig
+		s.Close()
+	})
+	return s
+}
+
+func TestDiscoverMergesNupnpResults(t *testing.T) {
+	nupnpServer(t, `[{"id":"1","internalipaddress":"192.168.1.2","port":443}]`)
+
+	ips, err := Discover(DiscoverOptions{Interfaces: []net.Interface{}})
+	if err != nil {
+		t.Fatalf("Discover returned error: %v", err)
+	}
+	if len(ips) != 1 || ips[0] != "192.168.1.2" {
+		t.Errorf("ips = %v, want [192.168.1.2]", ips)
+	}
+}
+
+func TestDiscoverStreamAppliesVerifyToNupnpOnly(t *testing.T) {
+	bridge := descriptionXMLServer(t, "Philips hue bridge")
+	defer bridge.Close()
+	other := descriptionXMLServer(t, "Some Other UPnP Device")
+	defer other.Close()
+
+	nupnpServer(t, fmt.Sprintf(
+		`[{"id":"1","internalipaddress":%q,"port":443},{"id":"2","internalipaddress":%q,"port":443}]`,
+		serverAddr(bridge), serverAddr(other)))
+
+	// SSDP has nothing to do with an empty interface list; bound the mDNS
+	// wait so the test doesn't pay out the full upnpTimeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	out, errs := DiscoverStream(ctx, DiscoverOptions{Verify: true, Interfaces: []net.Interface{}})
+
+	var got []string
+	for ip := range out {
+		got = append(got, ip)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("DiscoverStream returned error: %v", err)
+	}
+
+	want := []string{serverAddr(bridge)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want only the verified bridge %v", got, want)
+	}
+}
+
+func TestDiscoverStreamVerifyRespectsCancellation(t *testing.T) {
+	// A server that only answers once it's told to, so it stays slow for
+	// as long as the test needs it to without a real sleep.
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+			fmt.Fprint(w, `<root><device><modelName>Philips hue bridge</modelName></device></root>`)
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	nupnpServer(t, fmt.Sprintf(`[{"id":"1","internalipaddress":%q,"port":443}]`, serverAddr(slow)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out, errs := DiscoverStream(ctx, DiscoverOptions{Verify: true, Interfaces: []net.Interface{}})
+	time.AfterFunc(150*time.Millisecond, cancel)
+
+	start := time.Now()
+	for range out {
+	}
+	<-errs
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("DiscoverStream took %v to return after ctx was cancelled, want well under the server's response delay", elapsed)
+	}
+}
+
+func TestDiscoverContextReturnsNupnpResults(t *testing.T) {
+	nupnpServer(t, `[{"id":"1","internalipaddress":"192.168.1.2","port":443}]`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	ips, err := DiscoverContext(ctx, DiscoverOptions{Interfaces: []net.Interface{}})
+	if err != nil {
+		t.Fatalf("DiscoverContext returned error: %v", err)
+	}
+	if len(ips) != 1 || ips[0] != "192.168.1.2" {
+		t.Errorf("ips = %v, want [192.168.1.2]", ips)
+	}
+}
@@ -0,0 +1,346 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+// mDNS/DNS-SD discovery.
+//
+// Hue Bridge v2 advertises itself under _hue._tcp.local. in addition to
+// SSDP, and newer firmwares are starting to deprecate SSDP in favor of it.
+// This is a minimal, purpose-built DNS message encoder/decoder - just
+// enough to send a PTR query and read back the PTR/SRV/A/TXT answers a
+// bridge responds with. No general-purpose DNS library is pulled in for
+// it.
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const mdnsAddress = "224.0.0.251:5353"
+const hueServiceName = "_hue._tcp.local."
+
+const (
+	dnsTypeA   = 1
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+)
+
+// mdnsDiscover sends a DNS-SD PTR query for _hue._tcp.local. over
+// multicast and collects the IPv4 addresses of any bridges that answer
+// within timeout. It's a thin wrapper around mdnsDiscoverContext for
+// callers that don't need cancellation.
+func mdnsDiscover(timeout time.Duration) ([]string, error) {
+	return mdnsDiscoverContext(context.Background(), timeout)
+}
+
+// mdnsDiscoverContext is mdnsDiscover with early cancellation via ctx, so
+// e.g. DiscoverStream can abort it the moment a caller stops waiting
+// instead of always running the full timeout.
+func mdnsDiscoverContext(ctx context.Context, timeout time.Duration) ([]string, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", mdnsAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{Port: 0})
+	if err != nil {
+		return nil, err
+	}
+	defer socket.Close()
+
+	if _, err := socket.WriteToUDP(buildMDNSQuery(hueServiceName), raddr); err != nil {
+		return nil, err
+	}
+	socket.SetDeadline(time.Now().Add(timeout))
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			socket.Close() // unblocks ReadFromUDP below without waiting for timeout
+		case <-done:
+		}
+	}()
+
+	seen := make(map[string]bool)
+	var ips []string
+	for {
+		buf := make([]byte, 8192)
+		n, _, err := socket.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ips, nil
+			}
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return ips, nil
+			}
+			return ips, err
+		}
+
+		msg, err := parseMDNSMessage(buf[:n])
+		if err != nil {
+			continue // ignore malformed or unrelated packets
+		}
+
+		_, hasBridgeID := msg.bridgeID()
+		if !msg.advertisesHueService() && !hasBridgeID {
+			continue
+		}
+
+		for _, ip := range msg.ipv4Addresses() {
+			if seen[ip] {
+				continue
+			}
+			seen[ip] = true
+			ips = append(ips, ip)
+		}
+	}
+}
+
+// mdnsRR is a single parsed DNS resource record.
+type mdnsRR struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+}
+
+// mdnsMessage is a parsed DNS message, questions and all answer sections
+// merged together (we don't care which section a record came from - a
+// Hue bridge answers a PTR query with the PTR/SRV/TXT/A records for the
+// service all bundled into one packet).
+type mdnsMessage struct {
+	questions []string
+	answers   []mdnsRR
+}
+
+// advertisesHueService reports whether the message's question or any PTR
+// answer references the Hue DNS-SD service.
+func (m *mdnsMessage) advertisesHueService() bool {
+	for _, q := range m.questions {
+		if strings.EqualFold(q, hueServiceName) {
+			return true
+		}
+	}
+	for _, rr := range m.answers {
+		if rr.rtype == dnsTypePTR && strings.Contains(strings.ToLower(rr.name), "_hue._tcp") {
+			return true
+		}
+	}
+	return false
+}
+
+// ipv4Addresses returns the IPv4 addresses of every A record in the
+// message.
+func (m *mdnsMessage) ipv4Addresses() []string {
+	var ips []string
+	for _, rr := range m.answers {
+		if rr.rtype == dnsTypeA && len(rr.data) == 4 {
+			ips = append(ips, net.IP(rr.data).String())
+		}
+	}
+	return ips
+}
+
+// bridgeID returns the value of the "bridgeid" key from the first TXT
+// record that has one.
+func (m *mdnsMessage) bridgeID() (string, bool) {
+	for _, rr := range m.answers {
+		if rr.rtype != dnsTypeTXT {
+			continue
+		}
+		for _, attr := range decodeDNSTXT(rr.data) {
+			const prefix = "bridgeid="
+			if len(attr) >= len(prefix) && strings.EqualFold(attr[:len(prefix)], prefix) {
+				return attr[len(prefix):], true
+			}
+		}
+	}
+	return "", false
+}
+
+// buildMDNSQuery builds a standard DNS query packet asking for the PTR
+// records of name.
+func buildMDNSQuery(name string) []byte {
+	buf := make([]byte, 12) // header: ID=0, flags=0, QDCOUNT=1, AN/NS/ARCOUNT=0
+	binary.BigEndian.PutUint16(buf[4:6], 1)
+
+	buf = append(buf, encodeDNSName(name)...)
+	buf = append(buf, 0x00, dnsTypePTR)
+	buf = append(buf, 0x00, 0x01) // QCLASS IN
+	return buf
+}
+
+// encodeDNSName encodes name as a sequence of length-prefixed labels
+// terminated by a zero-length label.
+func encodeDNSName(name string) []byte {
+	var buf []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0x00)
+}
+
+// decodeDNSTXT splits a TXT record's rdata into its length-prefixed
+// attribute strings.
+func decodeDNSTXT(data []byte) []string {
+	var attrs []string
+	for i := 0; i < len(data); {
+		length := int(data[i])
+		i++
+		if i+length > len(data) {
+			break
+		}
+		attrs = append(attrs, string(data[i:i+length]))
+		i += length
+	}
+	return attrs
+}
+
+// parseMDNSMessage parses a raw DNS message into its questions and
+// answer records (authority and additional records are treated the same
+// as answers - we only care about the union of records returned).
+func parseMDNSMessage(buf []byte) (*mdnsMessage, error) {
+	if len(buf) < 12 {
+		return nil, errors.New("mdns: message too short")
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(buf[4:6]))
+	ancount := int(binary.BigEndian.Uint16(buf[6:8]))
+	nscount := int(binary.BigEndian.Uint16(buf[8:10]))
+	arcount := int(binary.BigEndian.Uint16(buf[10:12]))
+
+	msg := &mdnsMessage{}
+	offset := 12
+
+	for i := 0; i < qdcount; i++ {
+		name, next, err := decodeDNSName(buf, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(buf) {
+			return nil, errors.New("mdns: question out of bounds")
+		}
+		offset = next + 4 // QTYPE + QCLASS
+		msg.questions = append(msg.questions, name)
+	}
+
+	for i := 0; i < ancount+nscount+arcount; i++ {
+		rr, next, err := decodeDNSRR(buf, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+		msg.answers = append(msg.answers, rr)
+	}
+
+	return msg, nil
+}
+
+// decodeDNSRR decodes a single resource record starting at offset and
+// returns it along with the offset of the record that follows it.
+func decodeDNSRR(buf []byte, offset int) (mdnsRR, int, error) {
+	name, pos, err := decodeDNSName(buf, offset)
+	if err != nil {
+		return mdnsRR{}, 0, err
+	}
+	if pos+10 > len(buf) {
+		return mdnsRR{}, 0, errors.New("mdns: record header out of bounds")
+	}
+
+	rtype := binary.BigEndian.Uint16(buf[pos : pos+2])
+	class := binary.BigEndian.Uint16(buf[pos+2 : pos+4])
+	ttl := binary.BigEndian.Uint32(buf[pos+4 : pos+8])
+	rdlength := int(binary.BigEndian.Uint16(buf[pos+8 : pos+10]))
+	pos += 10
+
+	if pos+rdlength > len(buf) {
+		return mdnsRR{}, 0, errors.New("mdns: record data out of bounds")
+	}
+	data := buf[pos : pos+rdlength]
+	pos += rdlength
+
+	return mdnsRR{name: name, rtype: rtype, class: class & 0x7FFF, ttl: ttl, data: data}, pos, nil
+}
+
+// decodeDNSName decodes a (possibly compressed) domain name starting at
+// offset and returns the dotted name along with the offset of whatever
+// follows it in the message. Compression pointers are followed but don't
+// advance the returned offset past the two bytes of the pointer itself.
+func decodeDNSName(buf []byte, offset int) (string, int, error) {
+	var labels []string
+	pos := offset
+	pointerFollowed := -1
+	visited := make(map[int]bool)
+
+	for {
+		if pos >= len(buf) {
+			return "", 0, errors.New("mdns: name out of bounds")
+		}
+
+		length := int(buf[pos])
+		if length == 0 {
+			pos++
+			break
+		}
+
+		if length&0xC0 == 0xC0 {
+			if pos+1 >= len(buf) {
+				return "", 0, errors.New("mdns: truncated compression pointer")
+			}
+			// A pointer chain can only ever cross each offset in buf
+			// once in a well-formed message; a repeat means two (or
+			// more) pointers loop back on each other, which would
+			// otherwise spin forever instead of erroring out.
+			if visited[pos] {
+				return "", 0, errors.New("mdns: compression pointer loop")
+			}
+			visited[pos] = true
+
+			pointer := int(binary.BigEndian.Uint16(buf[pos:pos+2]) & 0x3FFF)
+			if pointerFollowed == -1 {
+				pointerFollowed = pos + 2
+			}
+			pos = pointer
+			continue
+		}
+
+		pos++
+		if pos+length > len(buf) {
+			return "", 0, errors.New("mdns: label out of bounds")
+		}
+		labels = append(labels, string(buf[pos:pos+length]))
+		pos += length
+	}
+
+	if pointerFollowed != -1 {
+		pos = pointerFollowed
+	}
+	return strings.Join(labels, ".") + ".", pos, nil
+}
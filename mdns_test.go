@@ -0,0 +1,149 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestDecodeDNSNamePlain(t *testing.T) {
+	buf := encodeDNSName("_hue._tcp.local.")
+	buf = append(buf, 0xAA) // trailing byte the name must not consume
+
+	name, next, err := decodeDNSName(buf, 0)
+	if err != nil {
+		t.Fatalf("decodeDNSName returned error: %v", err)
+	}
+	if name != "_hue._tcp.local." {
+		t.Errorf("name = %q, want %q", name, "_hue._tcp.local.")
+	}
+	if next != len(buf)-1 {
+		t.Errorf("next = %d, want %d", next, len(buf)-1)
+	}
+}
+
+func TestDecodeDNSNameCompressionPointer(t *testing.T) {
+	// buf: [target name at offset 0][pointer at later offset back to 0]
+	target := encodeDNSName("bridge._hue._tcp.local.")
+	pointerOffset := len(target)
+	buf := append(target, 0xC0, 0x00) // pointer to offset 0
+
+	name, next, err := decodeDNSName(buf, pointerOffset)
+	if err != nil {
+		t.Fatalf("decodeDNSName returned error: %v", err)
+	}
+	if name != "bridge._hue._tcp.local." {
+		t.Errorf("name = %q, want %q", name, "bridge._hue._tcp.local.")
+	}
+	if next != pointerOffset+2 {
+		t.Errorf("next = %d, want %d (just past the 2-byte pointer)", next, pointerOffset+2)
+	}
+}
+
+// TestDecodeDNSNamePointerLoop is a regression test for a hang: two
+// compression pointers that point at each other used to make
+// decodeDNSName loop forever instead of returning an error.
+func TestDecodeDNSNamePointerLoop(t *testing.T) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint16(buf[0:2], 0xC002) // offset 0 points to offset 2
+	binary.BigEndian.PutUint16(buf[2:4], 0xC000) // offset 2 points to offset 0
+
+	done := make(chan struct{})
+	var err error
+	go func() {
+		_, _, err = decodeDNSName(buf, 0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		if err == nil {
+			t.Fatal("decodeDNSName did not return an error for a pointer loop")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("decodeDNSName did not return - compression pointer loop was not detected")
+	}
+}
+
+func TestDecodeDNSTXT(t *testing.T) {
+	data := []byte{}
+	for _, s := range []string{"bridgeid=001788FFFE09A206", "other=ignored"} {
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+
+	attrs := decodeDNSTXT(data)
+	if len(attrs) != 2 {
+		t.Fatalf("decodeDNSTXT returned %d attrs, want 2: %v", len(attrs), attrs)
+	}
+	if attrs[0] != "bridgeid=001788FFFE09A206" {
+		t.Errorf("attrs[0] = %q", attrs[0])
+	}
+}
+
+func TestMdnsMessageBridgeID(t *testing.T) {
+	msg := &mdnsMessage{
+		answers: []mdnsRR{
+			{rtype: dnsTypeTXT, data: append([]byte{byte(len("bridgeid=001788FFFE09A206"))}, []byte("bridgeid=001788FFFE09A206")...)},
+		},
+	}
+
+	id, ok := msg.bridgeID()
+	if !ok {
+		t.Fatal("bridgeID() reported no bridgeid present")
+	}
+	if id != "001788FFFE09A206" {
+		t.Errorf("id = %q, want %q", id, "001788FFFE09A206")
+	}
+}
+
+func TestMdnsMessageBridgeIDEmptyValue(t *testing.T) {
+	msg := &mdnsMessage{
+		answers: []mdnsRR{
+			{rtype: dnsTypeTXT, data: append([]byte{byte(len("bridgeid="))}, []byte("bridgeid=")...)},
+		},
+	}
+
+	id, ok := msg.bridgeID()
+	if !ok {
+		t.Fatal("bridgeID() reported no bridgeid present for an exact 'bridgeid=' attribute")
+	}
+	if id != "" {
+		t.Errorf("id = %q, want empty string", id)
+	}
+}
+
+func TestMdnsMessageIPv4Addresses(t *testing.T) {
+	msg := &mdnsMessage{
+		answers: []mdnsRR{
+			{rtype: dnsTypeA, data: []byte{192, 168, 1, 2}},
+			{rtype: dnsTypeTXT, data: []byte{0}}, // not an A record, should be ignored
+		},
+	}
+
+	ips := msg.ipv4Addresses()
+	if len(ips) != 1 || ips[0] != "192.168.1.2" {
+		t.Errorf("ipv4Addresses() = %v, want [192.168.1.2]", ips)
+	}
+}
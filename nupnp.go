@@ -0,0 +1,93 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+// N-UPnP (cloud) discovery.
+//
+// Philips operates a portal that remembers the last known address of every
+// bridge that has ever phoned home. Querying it is the recommended fallback
+// for networks where SSDP multicast doesn't reach the bridge (guest Wi-Fi,
+// many enterprise VLANs, Docker bridge networks).
+//
+// FROM: https://developers.meethue.com/documentation/changes-bridge-discovery
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// nupnpEndpoint is a var rather than a const so tests can point it at an
+// httptest server instead of the real cloud portal.
+var nupnpEndpoint = "https://discovery.meethue.com/"
+
+const nupnpTimeout = 5 * time.Second
+
+// nupnpEntry mirrors a single element of the JSON array returned by the
+// N-UPnP portal, e.g. [{"id":"...","internalipaddress":"192.168.1.2","port":443}]
+type nupnpEntry struct {
+	ID                string `json:"id"`
+	InternalIPAddress string `json:"internalipaddress"`
+	Port              int    `json:"port"`
+}
+
+// nupnpDiscover queries Philips' cloud discovery portal and returns the
+// IP addresses of any bridges registered under the caller's public IP.
+// The request is bound to ctx so a caller like DiscoverStream can abort
+// it instead of always waiting out nupnpTimeout.
+func nupnpDiscover(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, nupnpEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: nupnpTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return parseNupnpResponse(resp.Body)
+}
+
+// parseNupnpResponse decodes the N-UPnP portal's JSON array response and
+// returns the IP address of every entry that has one. Split out from
+// nupnpDiscover so the parsing logic can be tested without a real HTTP
+// round trip.
+func parseNupnpResponse(r io.Reader) ([]string, error) {
+	var entries []nupnpEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	var ips []string
+	for _, entry := range entries {
+		if entry.InternalIPAddress == "" {
+			continue
+		}
+		ips = append(ips, entry.InternalIPAddress)
+	}
+	return ips, nil
+}
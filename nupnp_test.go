@@ -0,0 +1,83 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNupnpResponse(t *testing.T) {
+	body := `[{"id":"001788fffe09a206","internalipaddress":"192.168.1.2","port":443}]`
+
+	ips, err := parseNupnpResponse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("parseNupnpResponse returned error: %v", err)
+	}
+	if len(ips) != 1 || ips[0] != "192.168.1.2" {
+		t.Errorf("ips = %v, want [192.168.1.2]", ips)
+	}
+}
+
+func TestParseNupnpResponseMultipleEntries(t *testing.T) {
+	body := `[
+		{"id":"001788fffe09a206","internalipaddress":"192.168.1.2","port":443},
+		{"id":"001788fffe112233","internalipaddress":"192.168.1.3","port":443}
+	]`
+
+	ips, err := parseNupnpResponse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("parseNupnpResponse returned error: %v", err)
+	}
+	if len(ips) != 2 || ips[0] != "192.168.1.2" || ips[1] != "192.168.1.3" {
+		t.Errorf("ips = %v, want [192.168.1.2 192.168.1.3]", ips)
+	}
+}
+
+func TestParseNupnpResponseSkipsMissingIP(t *testing.T) {
+	body := `[{"id":"001788fffe09a206","internalipaddress":"","port":443}]`
+
+	ips, err := parseNupnpResponse(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("parseNupnpResponse returned error: %v", err)
+	}
+	if len(ips) != 0 {
+		t.Errorf("ips = %v, want none - an empty internalipaddress should be skipped", ips)
+	}
+}
+
+func TestParseNupnpResponseEmptyArray(t *testing.T) {
+	ips, err := parseNupnpResponse(strings.NewReader(`[]`))
+	if err != nil {
+		t.Fatalf("parseNupnpResponse returned error: %v", err)
+	}
+	if len(ips) != 0 {
+		t.Errorf("ips = %v, want none", ips)
+	}
+}
+
+func TestParseNupnpResponseInvalidJSON(t *testing.T) {
+	_, err := parseNupnpResponse(strings.NewReader(`not json`))
+	if err == nil {
+		t.Fatal("expected an error for invalid JSON")
+	}
+}
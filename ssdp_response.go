@@ -0,0 +1,105 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+// RFC-compliant parsing of the HTTP-like response an SSDP M-SEARCH
+// reply is. The previous implementation scanned the raw payload with
+// strings.Contains/SplitAfter, which lowercased the whole body (breaking
+// the case-sensitive path of the LOCATION URL),
+// assumed LF-only line endings, and fell over on anything but a bare
+// "http://host:port/path" LOCATION. This uses net/textproto to parse the
+// status line and headers the way the stdlib HTTP client does, then
+// net/url to make sense of LOCATION.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// ssdpResponse is a parsed SSDP response: its status line plus headers.
+// Keeping the full header set (rather than just a bool) means callers
+// that need more than LOCATION - e.g. hue-bridgeid - don't have to
+// re-parse the raw payload themselves.
+type ssdpResponse struct {
+	StatusLine string
+	Header     textproto.MIMEHeader
+}
+
+// parseSSDPResponse parses a raw SSDP response.
+func parseSSDPResponse(raw []byte) (*ssdpResponse, error) {
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(string(raw))))
+
+	statusLine, err := reader.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("reading SSDP status line: %w", err)
+	}
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return nil, fmt.Errorf("reading SSDP headers: %w", err)
+	}
+
+	return &ssdpResponse{StatusLine: strings.TrimSpace(statusLine), Header: header}, nil
+}
+
+// validateSSDPResponse checks resp against the rules a genuine Hue
+// bridge SSDP response must satisfy and, if it passes, returns the
+// LOCATION URL it advertised and its hue-bridgeid header (empty if it
+// didn't send one).
+func validateSSDPResponse(resp *ssdpResponse, origin net.IP) (valid bool, location *url.URL, bridgeID string, err error) {
+	if strings.HasPrefix(resp.StatusLine, "NOTIFY") {
+		return false, nil, "", nil // not an M-SEARCH reply, ignore
+	}
+	if !strings.HasPrefix(resp.StatusLine, "HTTP/1.1 200") {
+		return false, nil, "", fmt.Errorf("invalid SSDP response status line: %q", resp.StatusLine)
+	}
+
+	// Validate MUST fields (from UPnP Device Architecture 1.1)
+	if resp.Header.Get("USN") == "" || resp.Header.Get("ST") == "" {
+		return false, nil, "", errors.New("invalid SSDP response: missing USN/ST")
+	}
+
+	// Hue bridges send the string "IpBridge" in the SERVER field
+	// (see https://developers.meethue.com/documentation/hue-bridge-discovery)
+	if !strings.Contains(strings.ToLower(resp.Header.Get("SERVER")), "ipbridge") {
+		return false, nil, "", nil
+	}
+
+	raw := resp.Header.Get("LOCATION")
+	if raw == "" {
+		return false, nil, "", errors.New("invalid hue bridge response: missing LOCATION")
+	}
+	location, err = url.Parse(raw)
+	if err != nil {
+		return false, nil, "", fmt.Errorf("invalid LOCATION header %q: %w", raw, err)
+	}
+	if location.Hostname() != origin.String() {
+		return false, nil, "", errors.New("response and sender mismatch")
+	}
+
+	return true, location, resp.Header.Get("hue-bridgeid"), nil
+}
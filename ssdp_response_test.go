@@ -0,0 +1,156 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func hueSSDPResponse(location string) string {
+	return strings.Join([]string{
+		"HTTP/1.1 200 OK",
+		"HOST: 239.255.255.250:1900",
+		"CACHE-CONTROL: max-age=100",
+		"LOCATION: " + location,
+		"SERVER: FreeRTOS/7.4.2 UPnP/1.0 IpBridge/1.10.0",
+		"hue-bridgeid: 001788FFFE09A206",
+		"ST: upnp:rootdevice",
+		"USN: uuid:2f402f80-da50-11e1-9b23-00178809a206::upnp:rootdevice",
+		"", "",
+	}, "\r\n")
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	resp, err := parseSSDPResponse([]byte(hueSSDPResponse("http://192.168.178.241:80/description.xml")))
+	if err != nil {
+		t.Fatalf("parseSSDPResponse returned error: %v", err)
+	}
+	if resp.StatusLine != "HTTP/1.1 200 OK" {
+		t.Errorf("StatusLine = %q", resp.StatusLine)
+	}
+	if resp.Header.Get("ST") != "upnp:rootdevice" {
+		t.Errorf("ST header = %q", resp.Header.Get("ST"))
+	}
+	// hue-bridgeid's value case must survive header canonicalization.
+	if resp.Header.Get("hue-bridgeid") != "001788FFFE09A206" {
+		t.Errorf("hue-bridgeid header = %q", resp.Header.Get("hue-bridgeid"))
+	}
+}
+
+func TestValidateSSDPResponseValid(t *testing.T) {
+	origin := net.ParseIP("192.168.178.241")
+	resp, err := parseSSDPResponse([]byte(hueSSDPResponse("http://192.168.178.241:80/description.xml")))
+	if err != nil {
+		t.Fatalf("parseSSDPResponse returned error: %v", err)
+	}
+
+	valid, location, bridgeID, err := validateSSDPResponse(resp, origin)
+	if err != nil {
+		t.Fatalf("validateSSDPResponse returned error: %v", err)
+	}
+	if !valid {
+		t.Fatal("expected a valid Hue bridge response")
+	}
+	if location.Port() != "80" {
+		t.Errorf("location.Port() = %q, want 80", location.Port())
+	}
+	if bridgeID != "001788FFFE09A206" {
+		t.Errorf("bridgeID = %q", bridgeID)
+	}
+}
+
+func TestValidateSSDPResponseNonDefaultPort(t *testing.T) {
+	origin := net.ParseIP("192.168.178.241")
+	resp, err := parseSSDPResponse([]byte(hueSSDPResponse("http://192.168.178.241:8080/description.xml")))
+	if err != nil {
+		t.Fatalf("parseSSDPResponse returned error: %v", err)
+	}
+
+	valid, location, _, err := validateSSDPResponse(resp, origin)
+	if err != nil || !valid {
+		t.Fatalf("valid = %v, err = %v", valid, err)
+	}
+	if location.Port() != "8080" {
+		t.Errorf("location.Port() = %q, want 8080 (previously the port was ignored, 80 was assumed)", location.Port())
+	}
+}
+
+func TestValidateSSDPResponseSenderMismatch(t *testing.T) {
+	origin := net.ParseIP("10.0.0.99") // different from the LOCATION host
+	resp, err := parseSSDPResponse([]byte(hueSSDPResponse("http://192.168.178.241:80/description.xml")))
+	if err != nil {
+		t.Fatalf("parseSSDPResponse returned error: %v", err)
+	}
+
+	valid, _, _, err := validateSSDPResponse(resp, origin)
+	if err == nil || valid {
+		t.Fatalf("expected a sender-mismatch error, got valid=%v err=%v", valid, err)
+	}
+}
+
+func TestValidateSSDPResponseNotify(t *testing.T) {
+	body := strings.Join([]string{
+		"NOTIFY * HTTP/1.1",
+		"HOST: 239.255.255.250:1900",
+		"NTS: ssdp:alive",
+		"", "",
+	}, "\r\n")
+
+	resp, err := parseSSDPResponse([]byte(body))
+	if err != nil {
+		t.Fatalf("parseSSDPResponse returned error: %v", err)
+	}
+
+	valid, _, _, err := validateSSDPResponse(resp, net.ParseIP("192.168.178.241"))
+	if err != nil {
+		t.Fatalf("NOTIFY packets should be ignored, not errored on: %v", err)
+	}
+	if valid {
+		t.Fatal("NOTIFY packet should not validate as a search response")
+	}
+}
+
+func TestValidateSSDPResponseNonHueServer(t *testing.T) {
+	body := strings.Join([]string{
+		"HTTP/1.1 200 OK",
+		"LOCATION: http://192.168.178.241:80/description.xml",
+		"SERVER: Linux/3.14 UPnP/1.0 SomeOtherDevice/1.0",
+		"ST: upnp:rootdevice",
+		"USN: uuid:deadbeef::upnp:rootdevice",
+		"", "",
+	}, "\r\n")
+
+	resp, err := parseSSDPResponse([]byte(body))
+	if err != nil {
+		t.Fatalf("parseSSDPResponse returned error: %v", err)
+	}
+
+	valid, _, _, err := validateSSDPResponse(resp, net.ParseIP("192.168.178.241"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if valid {
+		t.Fatal("non-Hue SERVER header should not validate")
+	}
+}
@@ -41,9 +41,16 @@ import "net"
 import "strings"
 import "errors"
 import "fmt"
+import "sync"
+import "context"
 
 const upnpTimeout = 3 * time.Second
 
+// ssdpReadInterval bounds each individual ReadFromUDP call so the
+// discovery loop can check ctx.Done() frequently instead of blocking for
+// the full remaining timeout.
+const ssdpReadInterval = 200 * time.Millisecond
+
 // SSDP Payload - Make sure to keep linebreaks and indention untouched.
 const ssdpPayload = `M-SEARCH * HTTP/1.1
 HOST: 239.255.255.250:1900
@@ -53,37 +60,183 @@ MX: 2
 
 `
 
+// ssdpHit is a single validated SSDP response: the bridge's IP, the
+// LOCATION URL it advertised description.xml at, and its hue-bridgeid
+// header if it sent one.
+type ssdpHit struct {
+	ip       string
+	location string
+	bridgeID string
+}
+
+// upnpDiscover auto-detects discoverable interfaces and runs SSDP
+// discovery on all of them, returning the bare IPs of any bridges found.
+// It's a thin wrapper around upnpDiscoverOnInterfaces for callers that
+// don't need the LOCATION URL of each hit.
 func upnpDiscover(man string) ([]string, int, error) {
-	var responses []string
-	var valid []string
+	ifaces, err := discoverableInterfaces()
+	if err != nil {
+		return nil, 0, err
+	}
+	hits, responses, err := upnpDiscoverOnInterfaces(man, ifaces)
+
+	ips := make([]string, len(hits))
+	for i, hit := range hits {
+		ips[i] = hit.ip
+	}
+	return ips, responses, err
+}
+
+// upnpDiscoverOnInterfaces performs SSDP discovery on each of the given
+// interfaces concurrently and merges the results, deduplicating by IP.
+// It's a thin wrapper around upnpDiscoverOnInterfacesCtx that blocks for
+// the full upnpTimeout and collects every hit into a slice.
+func upnpDiscoverOnInterfaces(man string, ifaces []net.Interface) ([]ssdpHit, int, error) {
+	var valid []ssdpHit
+	responses, err := upnpDiscoverOnInterfacesCtx(context.Background(), man, ifaces, func(hit ssdpHit) {
+		valid = append(valid, hit)
+	})
+
+	if len(valid) == 0 && err != nil {
+		return valid, responses, err
+	}
+	return valid, responses, nil
+}
+
+// upnpDiscoverOnInterfacesCtx is the context-aware, streaming core of SSDP
+// discovery. It runs one goroutine per interface, deduplicates hits by
+// IP across all of them, and invokes found as soon as each new hit
+// arrives - rather than only after the whole discovery run completes -
+// so callers like DiscoverStream can surface bridges as they appear.
+// Discovery stops as soon as ctx is done, or after upnpTimeout, whichever
+// comes first.
+func upnpDiscoverOnInterfacesCtx(ctx context.Context, man string, ifaces []net.Interface, found func(ssdpHit)) (int, error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	responses := 0
+	var firstErr error
+
+	for _, iface := range ifaces {
+		iface := iface
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ifaceResponses, err := ssdpInterfaceDiscoverer(ctx, man, iface, func(hit ssdpHit) {
+				mu.Lock()
+				defer mu.Unlock()
+				if seen[hit.ip] {
+					return
+				}
+				seen[hit.ip] = true
+				found(hit)
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			responses += ifaceResponses
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}()
+	}
+	wg.Wait()
+
+	return responses, firstErr
+}
+
+// ssdpInterfaceDiscoverer runs SSDP discovery on a single interface. It's
+// a var rather than a direct call to upnpDiscoverOnInterfaceCtx so tests
+// can substitute a fake per-interface runner when exercising
+// upnpDiscoverOnInterfacesCtx's cross-interface dedup, without needing a
+// real socket per interface.
+var ssdpInterfaceDiscoverer = upnpDiscoverOnInterfaceCtx
+
+// upnpDiscoverOnInterface sends a single M-SEARCH from the given
+// interface's address and collects responses until upnpTimeout elapses.
+// It's a thin wrapper around upnpDiscoverOnInterfaceCtx for callers that
+// don't need cancellation.
+func upnpDiscoverOnInterface(man string, iface net.Interface) ([]ssdpHit, int, error) {
+	var valid []ssdpHit
+	responses, err := upnpDiscoverOnInterfaceCtx(context.Background(), man, iface, func(hit ssdpHit) {
+		valid = append(valid, hit)
+	})
+	return valid, responses, err
+}
+
+// upnpDiscoverOnInterfaceCtx sends a single M-SEARCH from the given
+// interface's address and invokes found for each validated response
+// until ctx is done or upnpTimeout elapses. Each ReadFromUDP call is
+// given its own short deadline (ssdpReadInterval) instead of one
+// deadline covering the whole timeout, so the loop gets a chance to
+// notice ctx.Done() instead of blocking until it would have timed out
+// anyway.
+func upnpDiscoverOnInterfaceCtx(ctx context.Context, man string, iface net.Interface, found func(ssdpHit)) (int, error) {
+	ip, err := ifaceIPv4Addr(iface)
+	if err != nil {
+		return 0, err
+	}
 
 	// Open listening port for incoming responses
-	socket, err := net.ListenUDP("udp4", &net.UDPAddr{Port: 1900})
+	socket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip, Port: 1900})
 	if err != nil {
-		return valid, len(responses), err
+		return 0, err
 	}
-	socket.SetDeadline(time.Now().Add(upnpTimeout))
 	defer socket.Close()
 
+	// Binding the socket to the interface's address only controls where
+	// we listen - the kernel still picks the multicast egress interface
+	// via IP_MULTICAST_IF independently of that, so without setting it
+	// explicitly every M-SEARCH below would go out whatever interface
+	// the default route picks, regardless of which iface we're supposed
+	// to be probing.
+	if err := setMulticastInterface(socket, ip); err != nil {
+		return 0, err
+	}
+
 	// Send out discovery request as broadcast
 	body := fmt.Sprintf(ssdpPayload, man)
 	rawBody := []byte(strings.Replace(body, "\n", "\r\n", -1))
-	_, err = socket.WriteToUDP(rawBody, &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900})
-	if err != nil {
-		return valid, len(responses), err
+	if _, err := socket.WriteToUDP(rawBody, &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}); err != nil {
+		return 0, err
 	}
 
-	// Loop over responses until timeout hits
+	return readSSDPResponses(ctx, socket, found)
+}
+
+// ssdpSocket is the subset of *net.UDPConn readSSDPResponses needs,
+// pulled out so its read/validate/cancel loop can be driven by a fake in
+// tests without real multicast traffic. *net.UDPConn satisfies this
+// interface as-is.
+type ssdpSocket interface {
+	SetReadDeadline(time.Time) error
+	ReadFromUDP([]byte) (int, *net.UDPAddr, error)
+}
+
+// readSSDPResponses reads and validates SSDP responses from socket,
+// invoking found for each new, valid hit (deduplicated by sender IP)
+// until ctx is done or upnpTimeout elapses.
+func readSSDPResponses(ctx context.Context, socket ssdpSocket, found func(ssdpHit)) (int, error) {
+	var responses []string
+	deadline := time.Now().Add(upnpTimeout)
+
+	// Loop over responses until ctx is done or the overall deadline hits
 loop:
 	for {
-		// Read response
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			return len(responses), nil
+		}
+
+		// Read response, but don't block past the next interval - that
+		// way a cancelled ctx is noticed well before upnpTimeout elapses.
+		socket.SetReadDeadline(time.Now().Add(ssdpReadInterval))
 		buf := make([]byte, 8192)
-		_, addr, err := socket.ReadFromUDP(buf)
+		n, addr, err := socket.ReadFromUDP(buf)
 		if err != nil {
-			if e, ok := err.(net.Error); !ok || !e.Timeout() {
-				return valid, len(responses), err //legitimate error, not a timeout.
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				continue // just the read interval, not the overall deadline
 			}
-			return valid, len(responses), nil // timeout
+			return len(responses), err //legitimate error, not a timeout.
 		}
 
 		// Response unique
@@ -94,54 +247,83 @@ loop:
 		}
 		responses = append(responses, addr.IP.String())
 
-		// Parse and validate response
-		body := string(buf)
-		val, err := ssdpResponseValid(body, addr.IP)
+		// Parse and validate response. Other devices on the LAN (printers,
+		// TVs, Sonos speakers, ...) answer ssdp:all too, so a malformed or
+		// non-Hue response here is expected noise, not a reason to abandon
+		// the rest of the read loop - keep listening for the real bridge.
+		resp, err := parseSSDPResponse(buf[:n])
+		if err != nil {
+			continue
+		}
+		val, location, bridgeID, err := validateSSDPResponse(resp, addr.IP)
 		if err != nil {
-			return valid, len(responses), err
+			continue
 		}
 		if !val {
 			continue // Ignore response
 		}
 
-		valid = append(valid, addr.IP.String())
+		found(ssdpHit{ip: addr.IP.String(), location: location.String(), bridgeID: bridgeID})
 	}
 }
 
-func ssdpResponseValid(body string, origin net.IP) (valid bool, err error) {
-	// Validate header
-	if !strings.Contains(body, "HTTP/1.1 200 OK") {
-		// ignore notify packages
-		if strings.Contains(body, "NOTIFY * HTTP/1.1") {
-			return false, nil
-		}
-		return false, errors.New(fmt.Sprintf("Invalid SSDP response header: %s", body))
+// resolveInterfaces returns override unchanged if the caller supplied
+// one, letting callers bypass auto-detection entirely (e.g. to scope
+// discovery to a single NIC, or to work around a host where
+// discoverableInterfaces guesses wrong). Otherwise it falls back to
+// discoverableInterfaces.
+func resolveInterfaces(override []net.Interface) ([]net.Interface, error) {
+	if override != nil {
+		return override, nil
 	}
+	return discoverableInterfaces()
+}
 
-	lower := strings.ToLower(body)
-	// Validate MUST fields (from UPnP Device Architecture 1.1)
-	if !strings.Contains(lower, "usn") || !strings.Contains(lower, "st") {
-		return false, errors.New("Invalid SSDP response")
+// discoverableInterfaces returns the set of interfaces SSDP discovery
+// should be attempted on: up, multicast-capable, non-loopback, with a
+// usable IPv4 address.
+func discoverableInterfaces() ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
 	}
 
-	// Hue bridges send string "IpBridge" in SERVER field
-	// (see https://developers.meethue.com/documentation/hue-bridge-discovery)
-	if !strings.Contains(lower, "ipbridge") {
-		return false, nil
+	var ifaces []net.Interface
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if _, err := ifaceIPv4Addr(iface); err != nil {
+			continue // no usable IPv4 address
+		}
+		ifaces = append(ifaces, iface)
 	}
+	return ifaces, nil
+}
 
-	// Validate IP in LOCATION field
-	if !strings.Contains(lower, "location") {
-		return false, errors.New("Invalid hue bridge response")
+// ifaceIPv4Addr returns the first unicast IPv4 address assigned to iface.
+func ifaceIPv4Addr(iface net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
 	}
-	s := strings.SplitAfter(lower, "location: ")
-	location := strings.Split(s[1], "\n")[0]
-	s = strings.SplitAfter(location, "http://")
-	ip := strings.Split(s[1], ":")[0]
-
-	if ip != origin.String() {
-		return false, errors.New("Response and sender mismatch")
+	for _, addr := range addrs {
+		var ip net.IP
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4, nil
+		}
 	}
-
-	return true, nil
+	return nil, errors.New("no usable IPv4 address")
 }
@@ -0,0 +1,56 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setMulticastInterface pins the multicast egress interface of socket to
+// the one owning ip, via IP_MULTICAST_IF. Windows' syscall package has
+// no SetsockoptInet4Addr helper (that's Unix-only), so this goes through
+// the lower-level Setsockopt with the option value encoded as raw bytes
+// instead.
+func setMulticastInterface(socket *net.UDPConn, ip net.IP) error {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return fmt.Errorf("not an IPv4 address: %s", ip)
+	}
+
+	raw, err := socket.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.Setsockopt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, &ip4[0], int32(len(ip4)))
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,294 @@
+// MIT License
+//
+// Copyright (c) 2017 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSSDPSocket implements ssdpSocket with a canned queue of packets, so
+// readSSDPResponses's read/validate/dedup/cancel loop can be driven
+// deterministically without a real socket or multicast traffic.
+type fakeSSDPSocket struct {
+	mu      sync.Mutex
+	packets []fakePacket
+}
+
+type fakePacket struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+func (s *fakeSSDPSocket) SetReadDeadline(time.Time) error { return nil }
+
+func (s *fakeSSDPSocket) ReadFromUDP(buf []byte) (int, *net.UDPAddr, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.packets) == 0 {
+		return 0, nil, fakeTimeoutError{}
+	}
+	p := s.packets[0]
+	s.packets = s.packets[1:]
+	return copy(buf, p.data), p.addr, nil
+}
+
+// fakeTimeoutError stands in for the net.Error a real read deadline
+// produces, since readSSDPResponses type-asserts for Timeout().
+type fakeTimeoutError struct{}
+
+func (fakeTimeoutError) Error() string   { return "i/o timeout" }
+func (fakeTimeoutError) Timeout() bool   { return true }
+func (fakeTimeoutError) Temporary() bool { return true }
+
+func udpAddr(ip string) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.ParseIP(ip), Port: 1900}
+}
+
+func TestReadSSDPResponsesValidHit(t *testing.T) {
+	socket := &fakeSSDPSocket{packets: []fakePacket{
+		{data: []byte(hueSSDPResponse("http://192.168.178.241:80/description.xml")), addr: udpAddr("192.168.178.241")},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var hits []ssdpHit
+	responses, err := readSSDPResponses(ctx, socket, func(hit ssdpHit) {
+		hits = append(hits, hit)
+	})
+	if err != nil {
+		t.Fatalf("readSSDPResponses returned error: %v", err)
+	}
+	if responses != 1 {
+		t.Errorf("responses = %d, want 1", responses)
+	}
+	if len(hits) != 1 || hits[0].ip != "192.168.178.241" || hits[0].bridgeID != "001788FFFE09A206" {
+		t.Errorf("hits = %+v, want one hit from 192.168.178.241", hits)
+	}
+}
+
+func TestReadSSDPResponsesDedupesRepeatedSenders(t *testing.T) {
+	packet := fakePacket{data: []byte(hueSSDPResponse("http://192.168.178.241:80/description.xml")), addr: udpAddr("192.168.178.241")}
+	socket := &fakeSSDPSocket{packets: []fakePacket{packet, packet}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var hits []ssdpHit
+	responses, err := readSSDPResponses(ctx, socket, func(hit ssdpHit) {
+		hits = append(hits, hit)
+	})
+	if err != nil {
+		t.Fatalf("readSSDPResponses returned error: %v", err)
+	}
+	if responses != 1 {
+		t.Errorf("responses = %d, want 1 - the second packet is from an already-seen sender", responses)
+	}
+	if len(hits) != 1 {
+		t.Errorf("hits = %v, want exactly one", hits)
+	}
+}
+
+func TestReadSSDPResponsesSkipsMalformedAndKeepsReading(t *testing.T) {
+	socket := &fakeSSDPSocket{packets: []fakePacket{
+		{data: []byte("not an SSDP response"), addr: udpAddr("192.168.178.1")},
+		{data: []byte(hueSSDPResponse("http://192.168.178.241:80/description.xml")), addr: udpAddr("192.168.178.241")},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var hits []ssdpHit
+	responses, err := readSSDPResponses(ctx, socket, func(hit ssdpHit) {
+		hits = append(hits, hit)
+	})
+	if err != nil {
+		t.Fatalf("readSSDPResponses returned error: %v", err)
+	}
+	if responses != 2 {
+		t.Errorf("responses = %d, want 2 - the malformed packet's sender still counts", responses)
+	}
+	if len(hits) != 1 || hits[0].ip != "192.168.178.241" {
+		t.Errorf("hits = %+v, want the valid response to still be found after the malformed one", hits)
+	}
+}
+
+func TestReadSSDPResponsesStopsOnReadError(t *testing.T) {
+	wantErr := errors.New("socket gone")
+	socket := &erroringSSDPSocket{err: wantErr}
+
+	_, err := readSSDPResponses(context.Background(), socket, func(ssdpHit) {})
+	if err != wantErr {
+		t.Errorf("err = %v, want %v", err, wantErr)
+	}
+}
+
+// erroringSSDPSocket always returns a fixed, non-timeout error, standing
+// in for a socket that's failed outright rather than just timed out.
+type erroringSSDPSocket struct{ err error }
+
+func (erroringSSDPSocket) SetReadDeadline(time.Time) error { return nil }
+func (s erroringSSDPSocket) ReadFromUDP([]byte) (int, *net.UDPAddr, error) {
+	return 0, nil, s.err
+}
+
+func TestReadSSDPResponsesStopsOnCtxCancel(t *testing.T) {
+	socket := &fakeSSDPSocket{} // never has a packet ready, always times out
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	responses, err := readSSDPResponses(ctx, socket, func(ssdpHit) {})
+	if err != nil {
+		t.Fatalf("readSSDPResponses returned error: %v", err)
+	}
+	if responses != 0 {
+		t.Errorf("responses = %d, want 0", responses)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("readSSDPResponses took %v to return after an already-cancelled ctx, want near-instant", elapsed)
+	}
+}
+
+func TestUpnpDiscoverOnInterfacesCtxDedupesAcrossInterfaces(t *testing.T) {
+	orig := ssdpInterfaceDiscoverer
+	defer func() { ssdpInterfaceDiscoverer = orig }()
+
+	// Both "interfaces" race to report the same bridge IP, the way two
+	// real NICs on the same LAN segment would.
+	ssdpInterfaceDiscoverer = func(ctx context.Context, man string, iface net.Interface, found func(ssdpHit)) (int, error) {
+		found(ssdpHit{ip: "192.168.1.5", location: "http://192.168.1.5:80/description.xml", bridgeID: "001788FFFE09A206"})
+		return 1, nil
+	}
+
+	ifaces := []net.Interface{{Index: 1, Name: "eth0"}, {Index: 2, Name: "eth1"}}
+
+	var mu sync.Mutex
+	var hits []ssdpHit
+	responses, err := upnpDiscoverOnInterfacesCtx(context.Background(), "ssdp:discover", ifaces, func(hit ssdpHit) {
+		mu.Lock()
+		hits = append(hits, hit)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("upnpDiscoverOnInterfacesCtx returned error: %v", err)
+	}
+	if responses != 2 {
+		t.Errorf("responses = %d, want 2 (one report from each interface)", responses)
+	}
+	if len(hits) != 1 {
+		t.Errorf("hits = %v, want exactly one deduplicated hit across both interfaces", hits)
+	}
+}
+
+func TestUpnpDiscoverOnInterfacesCtxAggregatesPartialFailure(t *testing.T) {
+	orig := ssdpInterfaceDiscoverer
+	defer func() { ssdpInterfaceDiscoverer = orig }()
+
+	wantErr := errors.New("boom")
+	ssdpInterfaceDiscoverer = func(ctx context.Context, man string, iface net.Interface, found func(ssdpHit)) (int, error) {
+		if iface.Name == "bad0" {
+			return 0, wantErr
+		}
+		found(ssdpHit{ip: "192.168.1.9", location: "http://192.168.1.9:80/description.xml"})
+		return 1, nil
+	}
+
+	ifaces := []net.Interface{{Name: "bad0"}, {Name: "good0"}}
+
+	var mu sync.Mutex
+	var hits []ssdpHit
+	responses, err := upnpDiscoverOnInterfacesCtx(context.Background(), "ssdp:discover", ifaces, func(hit ssdpHit) {
+		mu.Lock()
+		hits = append(hits, hit)
+		mu.Unlock()
+	})
+	if err != wantErr {
+		t.Errorf("err = %v, want %v", err, wantErr)
+	}
+	if responses != 1 {
+		t.Errorf("responses = %d, want 1 - only the healthy interface reported", responses)
+	}
+	if len(hits) != 1 {
+		t.Errorf("hits = %v, want the healthy interface's hit despite the other failing", hits)
+	}
+}
+
+func TestIfaceIPv4AddrLoopback(t *testing.T) {
+	iface, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface available: %v", err)
+	}
+
+	ip, err := ifaceIPv4Addr(*iface)
+	if err != nil {
+		t.Fatalf("ifaceIPv4Addr returned error: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("ip = %v, want 127.0.0.1", ip)
+	}
+}
+
+func TestDiscoverableInterfacesExcludesLoopback(t *testing.T) {
+	ifaces, err := discoverableInterfaces()
+	if err != nil {
+		t.Fatalf("discoverableInterfaces returned error: %v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			t.Errorf("discoverableInterfaces returned loopback interface %q", iface.Name)
+		}
+	}
+}
+
+func TestResolveInterfacesOverride(t *testing.T) {
+	override := []net.Interface{{Name: "eth9"}}
+
+	ifaces, err := resolveInterfaces(override)
+	if err != nil {
+		t.Fatalf("resolveInterfaces returned error: %v", err)
+	}
+	if !reflect.DeepEqual(ifaces, override) {
+		t.Errorf("ifaces = %v, want override returned unchanged", ifaces)
+	}
+}
+
+func TestResolveInterfacesAutoDetectsWhenNil(t *testing.T) {
+	ifaces, err := resolveInterfaces(nil)
+	if err != nil {
+		t.Fatalf("resolveInterfaces returned error: %v", err)
+	}
+	want, err := discoverableInterfaces()
+	if err != nil {
+		t.Fatalf("discoverableInterfaces returned error: %v", err)
+	}
+	if !reflect.DeepEqual(ifaces, want) {
+		t.Errorf("ifaces = %v, want %v", ifaces, want)
+	}
+}